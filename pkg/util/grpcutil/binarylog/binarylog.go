@@ -0,0 +1,213 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package binarylog
+
+import (
+	"sync/atomic"
+	"time"
+
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+const (
+	clientMessageEvent = pb.GrpcLogEntry_EVENT_TYPE_CLIENT_MESSAGE
+	serverMessageEvent = pb.GrpcLogEntry_EVENT_TYPE_SERVER_MESSAGE
+)
+
+// Logger dispatches GrpcLogEntry records for RPCs matched by a Config to a
+// Sink. A zero Logger discards everything; use NewLogger to wire up a real
+// Config and Sink.
+type Logger struct {
+	mu struct {
+		syncutil.RWMutex
+		cfg  *Config
+		sink Sink
+	}
+	seq int64
+}
+
+// NewLogger creates a Logger that will log RPCs matched by cfg to sink.
+func NewLogger(cfg *Config, sink Sink) *Logger {
+	l := &Logger{}
+	if cfg == nil {
+		cfg, _ = ParseConfig("")
+	}
+	if sink == nil {
+		sink = nopSink{}
+	}
+	l.mu.cfg = cfg
+	l.mu.sink = sink
+	return l
+}
+
+// Reconfigure atomically swaps the Config and Sink used by subsequent RPCs.
+// In-flight calls keep using the configuration that was active when they
+// started.
+func (l *Logger) Reconfigure(cfg *Config, sink Sink) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mu.cfg = cfg
+	l.mu.sink = sink
+}
+
+func (l *Logger) snapshot() (*Config, Sink) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.mu.cfg, l.mu.sink
+}
+
+func (l *Logger) nextSeq() uint64 {
+	return uint64(atomic.AddInt64(&l.seq, 1))
+}
+
+// callLogger accumulates state for a single RPC and emits entries to a
+// Sink, applying the truncation limits from a methodLoggerConfig.
+type callLogger struct {
+	l          *Logger
+	sink       Sink
+	cfg        methodLoggerConfig
+	fullMethod string
+	isClient   bool
+	peer       string
+}
+
+func (l *Logger) callLoggerFor(fullMethod string, isClient bool, p *peer.Peer) *callLogger {
+	cfg, sink := l.snapshot()
+	if cfg == nil || sink == nil {
+		return nil
+	}
+	mlCfg, ok := cfg.methodLoggerConfigFor(fullMethod)
+	if !ok {
+		return nil
+	}
+	var peerAddr string
+	if p != nil && p.Addr != nil {
+		peerAddr = p.Addr.String()
+	}
+	return &callLogger{
+		l: l, sink: sink, cfg: mlCfg,
+		fullMethod: fullMethod, isClient: isClient, peer: peerAddr,
+	}
+}
+
+// logHeader emits a Header binary log entry for md. typ is chosen by the
+// caller based on which header this is — EVENT_TYPE_CLIENT_HEADER for the
+// request header (sent by the client, whichever side is doing the
+// logging), EVENT_TYPE_SERVER_HEADER for the response header the server
+// sends back — not by c.isClient, which only says which side of the RPC
+// this callLogger belongs to.
+func (c *callLogger) logHeader(typ pb.GrpcLogEntry_EventType, md metadata.MD) {
+	logMD := truncateMetadata(md, c.cfg.Header)
+
+	entry := &pb.GrpcLogEntry{
+		Type:   typ,
+		Logger: c.loggerSide(),
+	}
+	switch typ {
+	case pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER:
+		entry.Payload = &pb.GrpcLogEntry_ClientHeader{
+			ClientHeader: &pb.ClientHeader{MethodName: c.fullMethod, Metadata: logMD},
+		}
+	case pb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER:
+		entry.Payload = &pb.GrpcLogEntry_ServerHeader{ServerHeader: &pb.ServerHeader{Metadata: logMD}}
+	default:
+		panic("logHeader: typ must be EVENT_TYPE_CLIENT_HEADER or EVENT_TYPE_SERVER_HEADER")
+	}
+	c.fill(entry)
+	_ = c.sink.Write(entry)
+}
+
+func (c *callLogger) logMessage(typ pb.GrpcLogEntry_EventType, msg []byte) {
+	origLen := len(msg)
+	if uint64(len(msg)) > c.cfg.Message {
+		msg = msg[:c.cfg.Message]
+	}
+	entry := &pb.GrpcLogEntry{
+		Type:    typ,
+		Logger:  c.loggerSide(),
+		Payload: &pb.GrpcLogEntry_Message{Message: &pb.Message{Data: msg, Length: uint32(origLen)}},
+	}
+	c.fill(entry)
+	_ = c.sink.Write(entry)
+}
+
+// logTrailer records the final status of the call. err should be nil for a
+// successful call; any non-nil error (that isn't io.EOF signaling a normal
+// stream close — callers are expected to have already translated that to
+// nil) is reported with its gRPC status code, so failed and successful
+// calls can be told apart in the binary log.
+func (c *callLogger) logTrailer(err error) {
+	entry := &pb.GrpcLogEntry{
+		Type:   pb.GrpcLogEntry_EVENT_TYPE_SERVER_TRAILER,
+		Logger: c.loggerSide(),
+		Payload: &pb.GrpcLogEntry_Trailer{Trailer: &pb.Trailer{
+			Status: &pb.Status{Code: int32(status.Code(err)), Message: errMessage(err)},
+		}},
+	}
+	c.fill(entry)
+	_ = c.sink.Write(entry)
+}
+
+func errMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func (c *callLogger) fill(entry *pb.GrpcLogEntry) {
+	entry.Timestamp = timestamp(timeutil.Now())
+	entry.SequenceIdWithinCall = c.l.nextSeq()
+	entry.Peer = &pb.Address{Address: c.peer}
+}
+
+func (c *callLogger) loggerSide() pb.GrpcLogEntry_Logger {
+	if c.isClient {
+		return pb.GrpcLogEntry_LOGGER_CLIENT
+	}
+	return pb.GrpcLogEntry_LOGGER_SERVER
+}
+
+func timestamp(t time.Time) *pb.Timestamp {
+	return &pb.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}
+}
+
+// truncateMetadata converts md into the binary logging wire format,
+// truncating each value to at most limit bytes. A limit of 0 (the default
+// when a rule's config doesn't request header logging) omits the metadata
+// entirely; maxUint means "don't truncate".
+func truncateMetadata(md metadata.MD, limit uint64) *pb.Metadata {
+	if limit == 0 || len(md) == 0 {
+		return nil
+	}
+	var entries []*pb.MetadataEntry
+	for k, vals := range md {
+		for _, v := range vals {
+			data := []byte(v)
+			if uint64(len(data)) > limit {
+				data = data[:limit]
+			}
+			entries = append(entries, &pb.MetadataEntry{Key: k, Value: data})
+		}
+	}
+	return &pb.Metadata{Entry: entries}
+}
@@ -0,0 +1,192 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package binarylog
+
+import (
+	"io"
+	"testing"
+
+	"google.golang.org/grpc"
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TestLogHeaderEventType verifies that logHeader's entry type and payload
+// are driven entirely by the typ argument, not by c.isClient: a server-side
+// callLogger logging the (client-sent) request header must still produce
+// EVENT_TYPE_CLIENT_HEADER carrying fullMethod, and logging the server's
+// own response header must produce EVENT_TYPE_SERVER_HEADER.
+func TestLogHeaderEventType(t *testing.T) {
+	cfg, err := ParseConfig("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewRingSink(4)
+	l := NewLogger(cfg, sink)
+	c := l.callLoggerFor("service/Method", false /* isClient */, nil)
+	if c == nil {
+		t.Fatal("callLoggerFor returned nil for a method matched by \"*\"")
+	}
+
+	c.logHeader(pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER, metadata.Pairs("k", "v"))
+	c.logHeader(pb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER, metadata.Pairs("k2", "v2"))
+
+	entries := sink.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if ch := entries[0].GetClientHeader(); ch == nil || ch.MethodName != "service/Method" {
+		t.Errorf("entry 0: ClientHeader = %+v, want MethodName %q", ch, "service/Method")
+	}
+	if sh := entries[1].GetServerHeader(); sh == nil {
+		t.Errorf("entry 1: ServerHeader = nil, want non-nil")
+	}
+}
+
+func TestTruncateMetadata(t *testing.T) {
+	md := metadata.Pairs("authorization", "bearer-token-that-is-long")
+
+	if got := truncateMetadata(md, 0); got != nil {
+		t.Errorf("limit=0: got %v, want nil", got)
+	}
+
+	got := truncateMetadata(md, maxUint)
+	if len(got.Entry) != 1 || string(got.Entry[0].Value) != "bearer-token-that-is-long" {
+		t.Errorf("limit=maxUint: got %+v, want full value retained", got)
+	}
+
+	got = truncateMetadata(md, 6)
+	if len(got.Entry) != 1 || string(got.Entry[0].Value) != "bearer" {
+		t.Errorf("limit=6: got %+v, want value truncated to \"bearer\"", got)
+	}
+}
+
+func TestLogTrailerStatusCode(t *testing.T) {
+	cfg, err := ParseConfig("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewRingSink(4)
+	l := NewLogger(cfg, sink)
+	c := l.callLoggerFor("service/Method", true /* isClient */, nil)
+	if c == nil {
+		t.Fatal("callLoggerFor returned nil for a method matched by \"*\"")
+	}
+
+	c.logTrailer(nil)
+	c.logTrailer(status.Error(codes.Unavailable, "transport is closing"))
+	c.logTrailer(io.EOF)
+
+	entries := sink.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	wantCodes := []codes.Code{codes.OK, codes.Unavailable, codes.Unknown}
+	for i, e := range entries {
+		trailer := e.GetTrailer()
+		if trailer == nil {
+			t.Fatalf("entry %d: no Trailer payload", i)
+		}
+		if got := codes.Code(trailer.Status.Code); got != wantCodes[i] {
+			t.Errorf("entry %d: Status.Code = %v, want %v", i, got, wantCodes[i])
+		}
+	}
+}
+
+func TestLogMessageReportsOriginalLengthWhenTruncated(t *testing.T) {
+	cfg, err := ParseConfig("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewRingSink(4)
+	l := NewLogger(cfg, sink)
+	c := l.callLoggerFor("service/Method", true /* isClient */, nil)
+	if c == nil {
+		t.Fatal("callLoggerFor returned nil for a method matched by \"*\"")
+	}
+	c.cfg.Message = 4
+
+	c.logMessage(clientMessageEvent, []byte("a message longer than the limit"))
+
+	entries := sink.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	msg := entries[0].GetMessage()
+	if msg == nil {
+		t.Fatal("entry has no Message payload")
+	}
+	if len(msg.Data) != 4 {
+		t.Errorf("Data length = %d, want 4 (truncated)", len(msg.Data))
+	}
+	if msg.Length != 31 {
+		t.Errorf("Length = %d, want 31 (original, untruncated size)", msg.Length)
+	}
+}
+
+// fakeClientStream is a grpc.ClientStream whose RecvMsg always returns a
+// fixed error, for exercising loggingClientStream.RecvMsg's trailer logging.
+type fakeClientStream struct {
+	grpc.ClientStream
+	recvErr error
+}
+
+func (s *fakeClientStream) RecvMsg(interface{}) error { return s.recvErr }
+
+func TestLoggingClientStreamRecvMsgEOFIsNotAFailure(t *testing.T) {
+	cfg, err := ParseConfig("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewRingSink(4)
+	l := NewLogger(cfg, sink)
+	c := l.callLoggerFor("service/Method", true /* isClient */, nil)
+	if c == nil {
+		t.Fatal("callLoggerFor returned nil for a method matched by \"*\"")
+	}
+
+	s := &loggingClientStream{ClientStream: &fakeClientStream{recvErr: io.EOF}, c: c}
+	if err := s.RecvMsg(nil); err != io.EOF {
+		t.Fatalf("RecvMsg() = %v, want io.EOF", err)
+	}
+
+	entries := sink.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	if got := codes.Code(entries[0].GetTrailer().Status.Code); got != codes.OK {
+		t.Errorf("trailer for io.EOF: Status.Code = %v, want OK", got)
+	}
+
+	sink2 := NewRingSink(4)
+	l2 := NewLogger(cfg, sink2)
+	c2 := l2.callLoggerFor("service/Method", true /* isClient */, nil)
+	realErr := status.Error(codes.Unavailable, "transport is closing")
+	s2 := &loggingClientStream{ClientStream: &fakeClientStream{recvErr: realErr}, c: c2}
+	if err := s2.RecvMsg(nil); err != realErr {
+		t.Fatalf("RecvMsg() = %v, want %v", err, realErr)
+	}
+	entries2 := sink2.Entries()
+	if len(entries2) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries2))
+	}
+	if got := codes.Code(entries2[0].GetTrailer().Status.Code); got != codes.Unavailable {
+		t.Errorf("trailer for real error: Status.Code = %v, want Unavailable", got)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+// Package binarylog wires grpc-go's binary logging framework into
+// CockroachDB so that RPCs between nodes can be captured for post-mortem
+// debugging without enabling full wire-level tracing.
+//
+// The configuration syntax mirrors grpc-go's own GRPC_BINARY_LOG_FILTER
+// format: a semicolon-separated list of rules of the form
+//
+//	service/method{h;m:1024}
+//
+// where the header/message options between braces are optional. '*' logs
+// every service and method; a leading '-' blacklists an exact
+// "service/method" that would otherwise match a preceding wildcard rule.
+// Matching grpc-go, a blacklist entry must name an exact method — "-*",
+// "-service" and "-service/*" are rejected as malformed.
+package binarylog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxUint is used as the "no truncation" header/message length.
+const maxUint = ^uint64(0)
+
+// methodLoggerConfig describes how much of a header or message to log for
+// RPCs matched by a rule, mirroring grpc-go's internal MethodLoggerConfig.
+type methodLoggerConfig struct {
+	// Header is the number of bytes of header metadata to log per entry.
+	// maxUint means "log everything".
+	Header uint64
+	// Message is the number of bytes of each message to log. maxUint means
+	// "log everything".
+	Message uint64
+}
+
+// rule is a single parsed entry of a Config, e.g. "service/method{h;m:1024}"
+// or "-service/method".
+type rule struct {
+	Service string
+	Method  string // empty means "all methods of Service"
+	Exclude bool
+	Config  methodLoggerConfig
+}
+
+// Config is a parsed binary logging filter configuration, matching the
+// semantics of grpc-go's binarylog config string (setServiceMethodLogger /
+// setMethodMethodLogger, including conflict detection).
+type Config struct {
+	// all is set when a "*" rule was specified, and holds the config for it.
+	all    *methodLoggerConfig
+	allExc bool
+	// services maps "service" -> config for a "service/*" rule.
+	services map[string]ruleEntry
+	// methods maps "service/method" -> config for a "service/method" rule.
+	methods map[string]ruleEntry
+}
+
+type ruleEntry struct {
+	Config  methodLoggerConfig
+	Exclude bool
+}
+
+// ParseConfig parses a binary logging filter configuration string as
+// described in the package doc comment. It returns an error describing the
+// first conflicting or malformed rule encountered.
+func ParseConfig(s string) (*Config, error) {
+	cfg := &Config{
+		services: make(map[string]ruleEntry),
+		methods:  make(map[string]ruleEntry),
+	}
+	if s == "" {
+		return cfg, nil
+	}
+	// Split on ';' only outside braces, so that the options of a rule like
+	// "service/method{h;m:1024}" stay with their rule.
+	var parts []string
+	depth, start := 0, 0
+	for i, ch := range s {
+		switch {
+		case ch == '{':
+			depth++
+		case ch == '}':
+			depth--
+		case ch == ';' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	for _, part := range append(parts, s[start:]) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		r, err := parseRule(part)
+		if err != nil {
+			return nil, fmt.Errorf("binarylog: invalid rule %q: %v", part, err)
+		}
+		if err := cfg.addRule(r); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+func parseRule(s string) (rule, error) {
+	r := rule{Config: methodLoggerConfig{Header: maxUint, Message: maxUint}}
+	if strings.HasPrefix(s, "-") {
+		r.Exclude = true
+		s = s[1:]
+	}
+
+	methodSpec := s
+	if idx := strings.IndexByte(s, '{'); idx != -1 {
+		if !strings.HasSuffix(s, "}") {
+			return r, fmt.Errorf("missing closing brace")
+		}
+		methodSpec = s[:idx]
+		opts, err := parseOptions(s[idx+1 : len(s)-1])
+		if err != nil {
+			return r, err
+		}
+		r.Config = opts
+	}
+
+	if methodSpec == "*" {
+		if r.Exclude {
+			return r, fmt.Errorf("blacklist rules must name an exact service/method, not \"-*\"")
+		}
+		r.Service, r.Method = "*", ""
+		return r, nil
+	}
+
+	parts := strings.SplitN(methodSpec, "/", 2)
+	r.Service = parts[0]
+	if r.Service == "" {
+		return r, fmt.Errorf("empty service name")
+	}
+	if len(parts) == 2 {
+		r.Method = parts[1]
+		if r.Method == "*" || r.Method == "" {
+			if r.Exclude {
+				return r, fmt.Errorf("blacklist rules must name an exact method, not a wildcard like %q", methodSpec)
+			}
+			r.Method = ""
+		}
+	} else if r.Exclude {
+		return r, fmt.Errorf("blacklist rules must name an exact service/method, not just a service like %q", methodSpec)
+	}
+	return r, nil
+}
+
+func parseOptions(s string) (methodLoggerConfig, error) {
+	cfg := methodLoggerConfig{Header: maxUint, Message: maxUint}
+	if s == "" {
+		return cfg, nil
+	}
+	// A bare "h" (or "m") means "log the full header (or message)"; the
+	// length may optionally be capped with ":<n>".
+	cfg.Header, cfg.Message = 0, 0
+	for _, opt := range strings.Split(s, ";") {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		kv := strings.SplitN(opt, ":", 2)
+		switch kv[0] {
+		case "h":
+			cfg.Header = maxUint
+		case "m":
+			cfg.Message = maxUint
+		default:
+			return cfg, fmt.Errorf("unknown option %q", kv[0])
+		}
+		if len(kv) == 2 {
+			n, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid length in option %q: %v", opt, err)
+			}
+			switch kv[0] {
+			case "h":
+				cfg.Header = n
+			case "m":
+				cfg.Message = n
+			}
+		}
+	}
+	return cfg, nil
+}
+
+func (c *Config) addRule(r rule) error {
+	entry := ruleEntry{Config: r.Config, Exclude: r.Exclude}
+	switch {
+	case r.Service == "*":
+		if c.all != nil {
+			return fmt.Errorf("binarylog: duplicate \"*\" rule")
+		}
+		cfgCopy := r.Config
+		c.all = &cfgCopy
+		c.allExc = r.Exclude
+	case r.Method == "":
+		if _, ok := c.services[r.Service]; ok {
+			return fmt.Errorf("binarylog: duplicate rule for service %q", r.Service)
+		}
+		c.services[r.Service] = entry
+	default:
+		key := r.Service + "/" + r.Method
+		if _, ok := c.methods[key]; ok {
+			return fmt.Errorf("binarylog: duplicate rule for method %q", key)
+		}
+		c.methods[key] = entry
+	}
+	return nil
+}
+
+// methodLoggerConfigFor returns the logging configuration that applies to
+// the given fully-qualified method ("/service/method"), and whether the
+// method should be logged at all. More specific rules (method > service >
+// "*") take precedence, and any matching blacklist entry wins over a less
+// specific whitelist entry.
+func (c *Config) methodLoggerConfigFor(fullMethod string) (methodLoggerConfig, bool) {
+	service, method := splitFullMethod(fullMethod)
+
+	if entry, ok := c.methods[service+"/"+method]; ok {
+		return entry.Config, !entry.Exclude
+	}
+	if entry, ok := c.services[service]; ok {
+		return entry.Config, !entry.Exclude
+	}
+	if c.all != nil {
+		return *c.all, !c.allExc
+	}
+	return methodLoggerConfig{}, false
+}
+
+func splitFullMethod(fullMethod string) (service, method string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	parts := strings.SplitN(fullMethod, "/", 2)
+	if len(parts) != 2 {
+		return fullMethod, ""
+	}
+	return parts[0], parts[1]
+}
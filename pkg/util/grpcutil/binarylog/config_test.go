@@ -0,0 +1,89 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package binarylog
+
+import "testing"
+
+func TestParseConfig(t *testing.T) {
+	testCases := []struct {
+		config     string
+		fullMethod string
+		wantLog    bool
+	}{
+		{"", "/cockroach.roachpb.Internal/Batch", false},
+		{"*", "/cockroach.roachpb.Internal/Batch", true},
+		{"cockroach.roachpb.Internal/*", "/cockroach.roachpb.Internal/Batch", true},
+		{"cockroach.roachpb.Internal/*", "/cockroach.rpc.Heartbeat/Ping", false},
+		{"cockroach.roachpb.Internal/Batch", "/cockroach.roachpb.Internal/Batch", true},
+		{"cockroach.roachpb.Internal/Batch", "/cockroach.roachpb.Internal/RangeFeed", false},
+		{"*;-cockroach.roachpb.Internal/Batch", "/cockroach.roachpb.Internal/Batch", false},
+		{"*;-cockroach.roachpb.Internal/Batch", "/cockroach.rpc.Heartbeat/Ping", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.config, func(t *testing.T) {
+			cfg, err := ParseConfig(tc.config)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, gotLog := cfg.methodLoggerConfigFor(tc.fullMethod)
+			if gotLog != tc.wantLog {
+				t.Errorf("methodLoggerConfigFor(%q) = %v, want %v", tc.fullMethod, gotLog, tc.wantLog)
+			}
+		})
+	}
+}
+
+func TestParseConfigTruncation(t *testing.T) {
+	cfg, err := ParseConfig("cockroach.roachpb.Internal/Batch{h;m:1024}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mlCfg, ok := cfg.methodLoggerConfigFor("/cockroach.roachpb.Internal/Batch")
+	if !ok {
+		t.Fatal("expected method to be logged")
+	}
+	if mlCfg.Header != maxUint {
+		t.Errorf("Header = %d, want unlimited", mlCfg.Header)
+	}
+	if mlCfg.Message != 1024 {
+		t.Errorf("Message = %d, want 1024", mlCfg.Message)
+	}
+}
+
+func TestParseConfigRejectsWildcardBlacklist(t *testing.T) {
+	for _, config := range []string{
+		"*;-*",
+		"*;-cockroach.roachpb.Internal",
+		"*;-cockroach.roachpb.Internal/*",
+		"*;-cockroach.roachpb.Internal/",
+	} {
+		t.Run(config, func(t *testing.T) {
+			if _, err := ParseConfig(config); err == nil {
+				t.Fatalf("expected error for wildcard blacklist rule in %q", config)
+			}
+		})
+	}
+}
+
+func TestParseConfigDuplicateRule(t *testing.T) {
+	if _, err := ParseConfig("*;*"); err == nil {
+		t.Fatal("expected error for duplicate \"*\" rule")
+	}
+	if _, err := ParseConfig("cockroach.roachpb.Internal/Batch;cockroach.roachpb.Internal/Batch"); err == nil {
+		t.Fatal("expected error for duplicate method rule")
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package binarylog
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+// ClientInterceptor returns a grpc.UnaryClientInterceptor that emits
+// Header/Message/Trailer binary log entries for each unary RPC matched by
+// l's Config.
+func (l *Logger) ClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		p, _ := peer.FromContext(ctx)
+		c := l.callLoggerFor(method, true /* isClient */, p)
+		if c == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		md, _ := metadata.FromOutgoingContext(ctx)
+		c.logHeader(pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER, md)
+		if m, ok := req.(marshaler); ok {
+			if buf, err := m.Marshal(); err == nil {
+				c.logMessage(clientMessageEvent, buf)
+			}
+		}
+
+		var respHeader metadata.MD
+		opts = append(opts, grpc.Header(&respHeader))
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		c.logHeader(pb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER, respHeader)
+
+		if m, ok := reply.(marshaler); ok {
+			if buf, err := m.Marshal(); err == nil {
+				c.logMessage(serverMessageEvent, buf)
+			}
+		}
+		c.logTrailer(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a grpc.StreamClientInterceptor that emits
+// a Header binary log entry when the stream is created; per-message and
+// trailer events are the responsibility of the wrapped ClientStream
+// returned here.
+func (l *Logger) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		p, _ := peer.FromContext(ctx)
+		c := l.callLoggerFor(method, true /* isClient */, p)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if c == nil || err != nil {
+			return cs, err
+		}
+		md, _ := metadata.FromOutgoingContext(ctx)
+		c.logHeader(pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER, md)
+		go func() {
+			if hdr, herr := cs.Header(); herr == nil {
+				c.logHeader(pb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER, hdr)
+			}
+		}()
+		return &loggingClientStream{ClientStream: cs, c: c}, nil
+	}
+}
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor that emits
+// Header/Message/Trailer binary log entries for each unary RPC matched by
+// l's Config.
+func (l *Logger) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		p, _ := peer.FromContext(ctx)
+		c := l.callLoggerFor(info.FullMethod, false /* isClient */, p)
+		if c == nil {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		c.logHeader(pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER, md)
+		if m, ok := req.(marshaler); ok {
+			if buf, err := m.Marshal(); err == nil {
+				c.logMessage(clientMessageEvent, buf)
+			}
+		}
+
+		var hdr *headerRecorder
+		if sts := grpc.ServerTransportStreamFromContext(ctx); sts != nil {
+			hdr = &headerRecorder{ServerTransportStream: sts}
+			ctx = grpc.NewContextWithServerTransportStream(ctx, hdr)
+		}
+
+		resp, err := handler(ctx, req)
+
+		if hdr != nil {
+			c.logHeader(pb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER, hdr.header())
+		}
+		if m, ok := resp.(marshaler); ok {
+			if buf, merr := m.Marshal(); merr == nil {
+				c.logMessage(serverMessageEvent, buf)
+			}
+		}
+		c.logTrailer(err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a grpc.StreamServerInterceptor that emits
+// a Header binary log entry when the stream is accepted; per-message and
+// trailer events are the responsibility of the wrapped ServerStream passed
+// to the handler.
+func (l *Logger) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		p, _ := peer.FromContext(ss.Context())
+		c := l.callLoggerFor(info.FullMethod, false /* isClient */, p)
+		if c == nil {
+			return handler(srv, ss)
+		}
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		c.logHeader(pb.GrpcLogEntry_EVENT_TYPE_CLIENT_HEADER, md)
+		err := handler(srv, &loggingServerStream{ServerStream: ss, c: c})
+		c.logTrailer(err)
+		return err
+	}
+}
+
+// marshaler is implemented by protobuf messages; it lets us extract raw
+// message bytes for logging without depending on a specific proto runtime.
+type marshaler interface {
+	Marshal() ([]byte, error)
+}
+
+type loggingClientStream struct {
+	grpc.ClientStream
+	c *callLogger
+}
+
+func (s *loggingClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if mm, ok := m.(marshaler); ok && err == nil {
+		if buf, merr := mm.Marshal(); merr == nil {
+			s.c.logMessage(clientMessageEvent, buf)
+		}
+	}
+	return err
+}
+
+func (s *loggingClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if mm, ok := m.(marshaler); ok && err == nil {
+		if buf, merr := mm.Marshal(); merr == nil {
+			s.c.logMessage(serverMessageEvent, buf)
+		}
+	}
+	if err != nil {
+		// io.EOF signals a normal, successful end of stream, not a failure;
+		// log it as such so post-mortem trailers can actually distinguish
+		// real failures from a stream simply running out of messages.
+		trailerErr := err
+		if err == io.EOF {
+			trailerErr = nil
+		}
+		s.c.logTrailer(trailerErr)
+	}
+	return err
+}
+
+type loggingServerStream struct {
+	grpc.ServerStream
+	c *callLogger
+}
+
+// SetHeader and SendHeader are overridden purely to observe the response
+// header a handler sets — the embedded grpc.ServerStream still does the
+// real work of delivering it to the client.
+func (s *loggingServerStream) SetHeader(md metadata.MD) error {
+	err := s.ServerStream.SetHeader(md)
+	s.c.logHeader(pb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER, md)
+	return err
+}
+
+func (s *loggingServerStream) SendHeader(md metadata.MD) error {
+	err := s.ServerStream.SendHeader(md)
+	s.c.logHeader(pb.GrpcLogEntry_EVENT_TYPE_SERVER_HEADER, md)
+	return err
+}
+
+func (s *loggingServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if mm, ok := m.(marshaler); ok && err == nil {
+		if buf, merr := mm.Marshal(); merr == nil {
+			s.c.logMessage(serverMessageEvent, buf)
+		}
+	}
+	return err
+}
+
+func (s *loggingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if mm, ok := m.(marshaler); ok && err == nil {
+		if buf, merr := mm.Marshal(); merr == nil {
+			s.c.logMessage(clientMessageEvent, buf)
+		}
+	}
+	return err
+}
+
+// headerRecorder wraps the grpc.ServerTransportStream installed in a unary
+// handler's context so that a call to grpc.SetHeader/grpc.SendHeader inside
+// the handler can be observed and logged as a SERVER_HEADER entry. Unlike
+// the streaming case, grpc.UnaryHandler exposes no stream to wrap directly,
+// so the interceptor has to swap in its own ServerTransportStream instead.
+type headerRecorder struct {
+	grpc.ServerTransportStream
+	mu syncutil.Mutex
+	md metadata.MD
+}
+
+func (r *headerRecorder) SetHeader(md metadata.MD) error {
+	r.mu.Lock()
+	r.md = metadata.Join(r.md, md)
+	r.mu.Unlock()
+	return r.ServerTransportStream.SetHeader(md)
+}
+
+func (r *headerRecorder) SendHeader(md metadata.MD) error {
+	r.mu.Lock()
+	r.md = metadata.Join(r.md, md)
+	r.mu.Unlock()
+	return r.ServerTransportStream.SendHeader(md)
+}
+
+func (r *headerRecorder) header() metadata.MD {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.md
+}
@@ -0,0 +1,37 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package binarylog
+
+import (
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+)
+
+// Sink receives GrpcLogEntry records produced by the binary logger. Writes
+// must be safe for concurrent use.
+type Sink interface {
+	// Write emits a single log entry. Implementations should not block the
+	// RPC path for long; slow sinks should buffer internally.
+	Write(*pb.GrpcLogEntry) error
+	// Close flushes and releases any resources held by the sink.
+	Close() error
+}
+
+// nopSink discards every entry. It is used when binary logging is
+// configured but no sink has been installed yet.
+type nopSink struct{}
+
+func (nopSink) Write(*pb.GrpcLogEntry) error { return nil }
+func (nopSink) Close() error                 { return nil }
@@ -0,0 +1,128 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package binarylog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/gogo/protobuf/proto"
+
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// FileSink is a Sink that appends length-prefixed, serialized
+// GrpcLogEntry records to a file, rotating to a new file once the current
+// one exceeds maxSizeBytes. Rotation never overwrites a previous file: each
+// new file's name embeds the wall-clock time and a monotonic generation
+// counter, so prior rotations are retained on disk for post-mortem use
+// rather than truncated away.
+type FileSink struct {
+	dir          string
+	prefix       string
+	maxSizeBytes int64
+
+	mu struct {
+		syncutil.Mutex
+		f          *os.File
+		size       int64
+		generation int64
+	}
+}
+
+// NewFileSink creates a FileSink that writes to files named
+// "<prefix>.<timestamp>.<generation>.binpb" inside dir, rotating to a new
+// file once the current one reaches maxSizeBytes.
+func NewFileSink(dir, prefix string, maxSizeBytes int64) (*FileSink, error) {
+	s := &FileSink{dir: dir, prefix: prefix, maxSizeBytes: maxSizeBytes}
+	if err := s.rotateLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) rotateLocked() error {
+	if s.mu.f != nil {
+		_ = s.mu.f.Close()
+	}
+	name := filepath.Join(s.dir, fmt.Sprintf(
+		"%s.%s.%06d.binpb", s.prefix, timeutil.Now().Format("20060102-150405.000000000"), s.mu.generation,
+	))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	s.mu.f = f
+	s.mu.size = 0
+	s.mu.generation++
+	return nil
+}
+
+// Write implements Sink.
+func (s *FileSink) Write(entry *pb.GrpcLogEntry) error {
+	buf, err := proto.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mu.size > 0 && s.mu.size+int64(len(buf)) > s.maxSizeBytes {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	prefixN, err := writeUvarint(s.mu.f, uint64(len(buf)))
+	s.mu.size += int64(prefixN)
+	if err != nil {
+		return err
+	}
+	n, err := s.mu.f.Write(buf)
+	s.mu.size += int64(n)
+	return err
+}
+
+// Close implements Sink.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mu.f == nil {
+		return nil
+	}
+	return s.mu.f.Close()
+}
+
+// writeUvarint writes v to w as a uvarint and returns the number of bytes
+// written, so callers can account for the prefix's own size (not just the
+// payload that follows it) when tracking how much they've written to w.
+func writeUvarint(w io.Writer, v uint64) (int, error) {
+	var buf [10]byte
+	n := 0
+	for v >= 0x80 {
+		buf[n] = byte(v) | 0x80
+		v >>= 7
+		n++
+	}
+	buf[n] = byte(v)
+	n++
+	return w.Write(buf[:n])
+}
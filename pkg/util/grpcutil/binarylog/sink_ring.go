@@ -0,0 +1,98 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package binarylog
+
+import (
+	"encoding/json"
+	"net/http"
+
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+)
+
+// RingSink is a Sink that retains only the most recent capacity entries in
+// memory, discarding older ones. It is exposed over the debug HTTP endpoint
+// so operators can inspect recent RPC traffic without configuring a file
+// sink ahead of time.
+type RingSink struct {
+	mu struct {
+		syncutil.Mutex
+		buf  []*pb.GrpcLogEntry
+		next int
+		full bool
+	}
+}
+
+// defaultRingCapacity is used by NewRingSink when capacity is not positive,
+// so a misconfigured capacity degrades to "keep only the most recent entry"
+// rather than panicking on the first Write.
+const defaultRingCapacity = 1
+
+// NewRingSink creates a RingSink retaining up to capacity entries.
+// Non-positive values are treated as defaultRingCapacity.
+func NewRingSink(capacity int) *RingSink {
+	if capacity <= 0 {
+		capacity = defaultRingCapacity
+	}
+	s := &RingSink{}
+	s.mu.buf = make([]*pb.GrpcLogEntry, capacity)
+	return s
+}
+
+// Write implements Sink.
+func (s *RingSink) Write(entry *pb.GrpcLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.buf[s.mu.next] = entry
+	s.mu.next++
+	if s.mu.next == len(s.mu.buf) {
+		s.mu.next = 0
+		s.mu.full = true
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (*RingSink) Close() error { return nil }
+
+// Entries returns a copy of the currently retained entries, oldest first.
+func (s *RingSink) Entries() []*pb.GrpcLogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.mu.full {
+		out := make([]*pb.GrpcLogEntry, s.mu.next)
+		copy(out, s.mu.buf[:s.mu.next])
+		return out
+	}
+	out := make([]*pb.GrpcLogEntry, 0, len(s.mu.buf))
+	out = append(out, s.mu.buf[s.mu.next:]...)
+	out = append(out, s.mu.buf[:s.mu.next]...)
+	return out
+}
+
+// ServeHTTP renders the retained entries as JSON, so a RingSink can be
+// registered on a debug HTTP mux (e.g. at /debug/grpc_binarylog). Nothing in
+// this package registers it.
+func (s *RingSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.Entries()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
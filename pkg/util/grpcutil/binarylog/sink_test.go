@@ -0,0 +1,99 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package binarylog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	pb "google.golang.org/grpc/binarylog/grpc_binarylog_v1"
+)
+
+func TestRingSinkZeroCapacity(t *testing.T) {
+	s := NewRingSink(0)
+	if err := s.Write(&pb.GrpcLogEntry{}); err != nil {
+		t.Fatalf("Write on a zero-capacity sink panicked or errored: %v", err)
+	}
+	if got := len(s.Entries()); got != 1 {
+		t.Errorf("Entries() len = %d, want 1", got)
+	}
+}
+
+func TestFileSinkSizeIncludesLengthPrefix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binarylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	s, err := NewFileSink(dir, "node1", 1<<20 /* maxSizeBytes: large enough not to rotate */)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	entry := &pb.GrpcLogEntry{SequenceIdWithinCall: 1}
+	buf, err := proto.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	gotSize := s.mu.size
+	s.mu.Unlock()
+
+	// The tracked size must cover both the payload and the uvarint length
+	// prefix written ahead of it; before the fix it only counted the
+	// payload, undercounting every record by its prefix length.
+	wantSize := int64(len(buf)) + 1 // payloads this small need a 1-byte uvarint prefix
+	if gotSize != wantSize {
+		t.Errorf("tracked size = %d, want %d (payload %d + 1-byte prefix)", gotSize, wantSize, len(buf))
+	}
+}
+
+func TestFileSinkRotationPreservesPriorFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binarylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	s, err := NewFileSink(dir, "node1", 1 /* maxSizeBytes: rotate on every write */)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+
+	for i := 0; i < 3; i++ {
+		if err := s.Write(&pb.GrpcLogEntry{SequenceIdWithinCall: uint64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "node1.*.binpb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("expected rotation to retain multiple files, got %v", files)
+	}
+}
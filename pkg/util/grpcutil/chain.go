@@ -0,0 +1,126 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// ChainUnaryClient composes a series of grpc.UnaryClientInterceptor into a
+// single interceptor, in the order they're passed: the first interceptor is
+// outermost (runs first on the way in, last on the way out), mirroring
+// go-grpc-middleware's grpc_middleware.ChainUnaryClient.
+func ChainUnaryClient(interceptors ...grpc.UnaryClientInterceptor) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		chained := invoker
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			chained = bindUnaryClientInterceptor(interceptors[i], chained)
+		}
+		return chained(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func bindUnaryClientInterceptor(
+	interceptor grpc.UnaryClientInterceptor, next grpc.UnaryInvoker,
+) grpc.UnaryInvoker {
+	return func(
+		ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption,
+	) error {
+		return interceptor(ctx, method, req, reply, cc, next, opts...)
+	}
+}
+
+// ChainStreamClient composes a series of grpc.StreamClientInterceptor into
+// a single interceptor, with the same ordering semantics as
+// ChainUnaryClient.
+func ChainStreamClient(interceptors ...grpc.StreamClientInterceptor) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		chained := streamer
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			chained = bindStreamClientInterceptor(interceptors[i], chained)
+		}
+		return chained(ctx, desc, cc, method, opts...)
+	}
+}
+
+func bindStreamClientInterceptor(
+	interceptor grpc.StreamClientInterceptor, next grpc.Streamer,
+) grpc.Streamer {
+	return func(
+		ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return interceptor(ctx, desc, cc, method, next, opts...)
+	}
+}
+
+// ChainUnaryServer composes a series of grpc.UnaryServerInterceptor into a
+// single interceptor: the first interceptor is outermost, mirroring
+// go-grpc-middleware's grpc_middleware.ChainUnaryServer.
+func ChainUnaryServer(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			chained = bindUnaryServerInterceptor(interceptors[i], info, chained)
+		}
+		return chained(ctx, req)
+	}
+}
+
+func bindUnaryServerInterceptor(
+	interceptor grpc.UnaryServerInterceptor, info *grpc.UnaryServerInfo, next grpc.UnaryHandler,
+) grpc.UnaryHandler {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		return interceptor(ctx, req, info, next)
+	}
+}
+
+// ChainStreamServer composes a series of grpc.StreamServerInterceptor into
+// a single interceptor, with the same ordering semantics as
+// ChainUnaryServer.
+func ChainStreamServer(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			chained = bindStreamServerInterceptor(interceptors[i], info, chained)
+		}
+		return chained(srv, ss)
+	}
+}
+
+func bindStreamServerInterceptor(
+	interceptor grpc.StreamServerInterceptor, info *grpc.StreamServerInfo, next grpc.StreamHandler,
+) grpc.StreamHandler {
+	return func(srv interface{}, ss grpc.ServerStream) error {
+		return interceptor(srv, ss, info, next)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestChainUnaryServer(t *testing.T) {
+	var order []string
+	mkInterceptor := func(name string) grpc.UnaryServerInterceptor {
+		return func(
+			ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			order = append(order, "before:"+name)
+			resp, err := handler(ctx, req)
+			order = append(order, "after:"+name)
+			return resp, err
+		}
+	}
+
+	chain := ChainUnaryServer(mkInterceptor("outer"), mkInterceptor("inner"))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		order = append(order, "handler")
+		return nil, nil
+	}
+
+	if _, err := chain(context.Background(), nil, &grpc.UnaryServerInfo{}, handler); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"before:outer", "before:inner", "handler", "after:inner", "after:outer"}
+	if len(order) != len(want) {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got %v, want %v", order, want)
+		}
+	}
+}
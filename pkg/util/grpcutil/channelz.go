@@ -0,0 +1,301 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/channelz/service"
+
+	"github.com/cockroachdb/cockroach/pkg/settings"
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+)
+
+// ChannelzEnabled controls whether a node registers grpc-go's channelz
+// service and serves the /_status/channelz debug endpoints. Channelz has
+// nonzero overhead (it tracks every channel, subchannel and socket), so it
+// defaults to off.
+var ChannelzEnabled = settings.RegisterBoolSetting(
+	"server.channelz.enabled",
+	"if set, the node exposes grpc-go's channelz service and the "+
+		"/_status/channelz debug endpoints",
+	false,
+)
+
+// RegisterChannelz registers grpc-go's channelz service on server if
+// ChannelzEnabled is set. It should be called once per node, before the
+// gRPC server starts serving.
+func RegisterChannelz(server *grpc.Server, sv *settings.Values) {
+	if !ChannelzEnabled.Get(sv) {
+		return
+	}
+	service.RegisterChannelzServiceToServer(server)
+}
+
+// channelzClient is the subset of channelzpb.ChannelzClient that this file
+// needs; it lets tests substitute a fake without dialing a real server.
+// grpc-go deliberately keeps its channel/subchannel/socket bookkeeping
+// under an internal package that only code rooted at
+// google.golang.org/grpc may import, so — like grpc-go's own debug
+// tooling (e.g. the grpcdebug/channelz CLI) — we read it back out over
+// the channelz gRPC service we just registered above, rather than
+// reaching into grpc-go internals.
+type channelzClient interface {
+	GetTopChannels(ctx context.Context, in *channelzpb.GetTopChannelsRequest, opts ...grpc.CallOption) (*channelzpb.GetTopChannelsResponse, error)
+	GetServers(ctx context.Context, in *channelzpb.GetServersRequest, opts ...grpc.CallOption) (*channelzpb.GetServersResponse, error)
+	GetSubchannel(ctx context.Context, in *channelzpb.GetSubchannelRequest, opts ...grpc.CallOption) (*channelzpb.GetSubchannelResponse, error)
+	GetSocket(ctx context.Context, in *channelzpb.GetSocketRequest, opts ...grpc.CallOption) (*channelzpb.GetSocketResponse, error)
+	GetServerSockets(ctx context.Context, in *channelzpb.GetServerSocketsRequest, opts ...grpc.CallOption) (*channelzpb.GetServerSocketsResponse, error)
+}
+
+// NewChannelzClient wraps cc, a connection to a node's own gRPC server
+// (typically dialed against its loopback/advertised RPC address once
+// RegisterChannelz has run), for use by ChannelzMetrics.Snapshot, Handler
+// and DumpJSON.
+func NewChannelzClient(cc *grpc.ClientConn) channelzpb.ChannelzClient {
+	return channelzpb.NewChannelzClient(cc)
+}
+
+// ChannelzMetrics holds the Prometheus gauges exported from the channelz
+// trees. They are refreshed each time Snapshot is called.
+type ChannelzMetrics struct {
+	ChannelsTotal    *metric.Gauge
+	SubchannelsTotal *metric.Gauge
+	CallsFailedTotal *metric.Gauge
+}
+
+var metaChannelsTotal = metric.Metadata{
+	Name: "rpc.channelz.channels_total", Help: "Number of gRPC channels tracked by channelz",
+}
+var metaSubchannelsTotal = metric.Metadata{
+	Name: "rpc.channelz.subchannels_total", Help: "Number of gRPC subchannels tracked by channelz",
+}
+var metaCallsFailedTotal = metric.Metadata{
+	Name: "rpc.channelz.calls_failed_total", Help: "Number of failed gRPC calls summed across all channels, from channelz",
+}
+
+// NewChannelzMetrics constructs a ChannelzMetrics with fresh gauges.
+func NewChannelzMetrics() *ChannelzMetrics {
+	return &ChannelzMetrics{
+		ChannelsTotal:    metric.NewGauge(metaChannelsTotal),
+		SubchannelsTotal: metric.NewGauge(metaSubchannelsTotal),
+		CallsFailedTotal: metric.NewGauge(metaCallsFailedTotal),
+	}
+}
+
+// allTopChannels pages through GetTopChannels until the server reports it
+// has sent the last page.
+func allTopChannels(ctx context.Context, c channelzClient) ([]*channelzpb.Channel, error) {
+	var out []*channelzpb.Channel
+	var start int64
+	for {
+		resp, err := c.GetTopChannels(ctx, &channelzpb.GetTopChannelsRequest{StartChannelId: start})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, resp.Channel...)
+		if resp.End || len(resp.Channel) == 0 {
+			return out, nil
+		}
+		start = out[len(out)-1].Ref.ChannelId + 1
+	}
+}
+
+// Snapshot walks the channelz trees and updates m to reflect their current
+// state. It is intended to be called periodically (e.g. from a node's
+// metrics-refresh loop) when channelz is enabled.
+func (m *ChannelzMetrics) Snapshot(ctx context.Context, c channelzClient) error {
+	top, err := allTopChannels(ctx, c)
+	if err != nil {
+		return err
+	}
+	m.ChannelsTotal.Update(int64(len(top)))
+
+	var subchannels int64
+	var callsFailed int64
+	for _, ch := range top {
+		subchannels += int64(len(ch.SubchannelRef))
+		if data := ch.Data; data != nil {
+			callsFailed += data.CallsFailed
+		}
+	}
+	m.SubchannelsTotal.Update(subchannels)
+	m.CallsFailedTotal.Update(callsFailed)
+	return nil
+}
+
+// allServerSockets pages through GetServerSockets until the server reports
+// it has sent the last page.
+func allServerSockets(
+	ctx context.Context, c channelzClient, serverID int64,
+) ([]*channelzpb.SocketRef, error) {
+	var out []*channelzpb.SocketRef
+	var start int64
+	for {
+		resp, err := c.GetServerSockets(
+			ctx, &channelzpb.GetServerSocketsRequest{ServerId: serverID, StartSocketId: start},
+		)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, resp.SocketRef...)
+		if resp.End || len(resp.SocketRef) == 0 {
+			return out, nil
+		}
+		start = out[len(out)-1].SocketId + 1
+	}
+}
+
+// resolveSockets fetches the full Socket (including its Security info) for
+// each ref, in order.
+func resolveSockets(
+	ctx context.Context, c channelzClient, refs []*channelzpb.SocketRef,
+) ([]*channelzpb.Socket, error) {
+	sockets := make([]*channelzpb.Socket, len(refs))
+	for i, ref := range refs {
+		resp, err := c.GetSocket(ctx, &channelzpb.GetSocketRequest{SocketId: ref.SocketId})
+		if err != nil {
+			return nil, err
+		}
+		sockets[i] = resp.Socket
+	}
+	return sockets, nil
+}
+
+// subchannelJSON is a Subchannel with its Sockets resolved from the bare
+// SocketRefs the channelz service hands back.
+type subchannelJSON struct {
+	*channelzpb.Subchannel
+	Sockets []*channelzpb.Socket `json:"sockets,omitempty"`
+}
+
+// resolveSubchannels fetches the full Subchannel (and, in turn, its
+// Sockets) for each ref, in order.
+func resolveSubchannels(
+	ctx context.Context, c channelzClient, refs []*channelzpb.SubchannelRef,
+) ([]*subchannelJSON, error) {
+	out := make([]*subchannelJSON, len(refs))
+	for i, ref := range refs {
+		resp, err := c.GetSubchannel(ctx, &channelzpb.GetSubchannelRequest{SubchannelId: ref.SubchannelId})
+		if err != nil {
+			return nil, err
+		}
+		sockets, err := resolveSockets(ctx, c, resp.Subchannel.SocketRef)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = &subchannelJSON{Subchannel: resp.Subchannel, Sockets: sockets}
+	}
+	return out, nil
+}
+
+// channelJSON is a Channel with its Subchannels resolved from the bare
+// SubchannelRefs the channelz service hands back.
+type channelJSON struct {
+	*channelzpb.Channel
+	Subchannels []*subchannelJSON `json:"subchannels,omitempty"`
+}
+
+// serverJSON is a Server with the connection Sockets listed by
+// GetServerSockets resolved via GetSocket. The Server's listen sockets are
+// not resolved; they stay as the bare ListenSocket refs of the Server
+// message.
+type serverJSON struct {
+	*channelzpb.Server
+	Sockets []*channelzpb.Socket `json:"sockets,omitempty"`
+}
+
+// channelzTreeJSON is the JSON shape served by Handler and dumped by
+// `cockroach debug channelz`.
+type channelzTreeJSON struct {
+	TopChannels []*channelJSON `json:"top_channels"`
+	Servers     []*serverJSON  `json:"servers"`
+}
+
+func channelzTree(ctx context.Context, c channelzClient) (channelzTreeJSON, error) {
+	top, err := allTopChannels(ctx, c)
+	if err != nil {
+		return channelzTreeJSON{}, err
+	}
+	channels := make([]*channelJSON, len(top))
+	for i, ch := range top {
+		subchannels, err := resolveSubchannels(ctx, c, ch.SubchannelRef)
+		if err != nil {
+			return channelzTreeJSON{}, err
+		}
+		channels[i] = &channelJSON{Channel: ch, Subchannels: subchannels}
+	}
+
+	serversResp, err := c.GetServers(ctx, &channelzpb.GetServersRequest{})
+	if err != nil {
+		return channelzTreeJSON{}, err
+	}
+	servers := make([]*serverJSON, len(serversResp.Server))
+	for i, srv := range serversResp.Server {
+		refs, err := allServerSockets(ctx, c, srv.Ref.ServerId)
+		if err != nil {
+			return channelzTreeJSON{}, err
+		}
+		sockets, err := resolveSockets(ctx, c, refs)
+		if err != nil {
+			return channelzTreeJSON{}, err
+		}
+		servers[i] = &serverJSON{Server: srv, Sockets: sockets}
+	}
+
+	return channelzTreeJSON{TopChannels: channels, Servers: servers}, nil
+}
+
+// Handler renders the channelz tree (top channels, subchannels, sockets and
+// server sockets, with their cumulative call counts, last-call timestamps
+// and security info) as JSON, reading it from c. Nothing in this package
+// mounts it; the caller registers it on an HTTP mux (at /_status/channelz,
+// the path named by ChannelzEnabled). Handler itself does not consult
+// ChannelzEnabled.
+func Handler(c channelzClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tree, err := channelzTree(r.Context(), c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(tree); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// DumpJSON renders the same tree as Handler, but returns the bytes
+// directly rather than writing an HTTP response, so a caller outside an
+// HTTP handler (e.g. a `cockroach debug channelz` CLI subcommand, fetching
+// this payload over the admin RPC connection of a running node) can get at
+// it too. This package only provides the helper: this checkout has no
+// pkg/cli to wire an actual subcommand into, so the CLI command itself is
+// deferred to whoever adds one there, against NewChannelzClient/DumpJSON.
+func DumpJSON(ctx context.Context, c channelzClient) ([]byte, error) {
+	tree, err := channelzTree(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(tree, "", "  ")
+}
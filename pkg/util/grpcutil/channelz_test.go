@@ -0,0 +1,160 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+// fakeChannelzClient is an in-memory stand-in for channelzpb.ChannelzClient
+// that hands back a single fixed page of results, so Snapshot/Handler/
+// DumpJSON can be exercised without dialing a real gRPC server.
+type fakeChannelzClient struct {
+	channels      []*channelzpb.Channel
+	servers       []*channelzpb.Server
+	subchannels   map[int64]*channelzpb.Subchannel
+	sockets       map[int64]*channelzpb.Socket
+	serverSockets map[int64][]*channelzpb.SocketRef
+}
+
+func (f *fakeChannelzClient) GetTopChannels(
+	context.Context, *channelzpb.GetTopChannelsRequest, ...grpc.CallOption,
+) (*channelzpb.GetTopChannelsResponse, error) {
+	return &channelzpb.GetTopChannelsResponse{Channel: f.channels, End: true}, nil
+}
+
+func (f *fakeChannelzClient) GetServers(
+	context.Context, *channelzpb.GetServersRequest, ...grpc.CallOption,
+) (*channelzpb.GetServersResponse, error) {
+	return &channelzpb.GetServersResponse{Server: f.servers, End: true}, nil
+}
+
+func (f *fakeChannelzClient) GetSubchannel(
+	_ context.Context, in *channelzpb.GetSubchannelRequest, _ ...grpc.CallOption,
+) (*channelzpb.GetSubchannelResponse, error) {
+	return &channelzpb.GetSubchannelResponse{Subchannel: f.subchannels[in.SubchannelId]}, nil
+}
+
+func (f *fakeChannelzClient) GetSocket(
+	_ context.Context, in *channelzpb.GetSocketRequest, _ ...grpc.CallOption,
+) (*channelzpb.GetSocketResponse, error) {
+	return &channelzpb.GetSocketResponse{Socket: f.sockets[in.SocketId]}, nil
+}
+
+func (f *fakeChannelzClient) GetServerSockets(
+	_ context.Context, in *channelzpb.GetServerSocketsRequest, _ ...grpc.CallOption,
+) (*channelzpb.GetServerSocketsResponse, error) {
+	return &channelzpb.GetServerSocketsResponse{SocketRef: f.serverSockets[in.ServerId], End: true}, nil
+}
+
+func testClient() *fakeChannelzClient {
+	return &fakeChannelzClient{
+		channels: []*channelzpb.Channel{
+			{
+				Ref:           &channelzpb.ChannelRef{ChannelId: 1, Name: "127.0.0.1:26257"},
+				Data:          &channelzpb.ChannelData{CallsFailed: 3},
+				SubchannelRef: []*channelzpb.SubchannelRef{{SubchannelId: 1}},
+			},
+		},
+		servers: []*channelzpb.Server{
+			{Ref: &channelzpb.ServerRef{ServerId: 1, Name: "server"}},
+		},
+		subchannels: map[int64]*channelzpb.Subchannel{
+			1: {
+				Ref:       &channelzpb.SubchannelRef{SubchannelId: 1},
+				SocketRef: []*channelzpb.SocketRef{{SocketId: 1}},
+			},
+		},
+		sockets: map[int64]*channelzpb.Socket{
+			1: {
+				Ref:      &channelzpb.SocketRef{SocketId: 1, Name: "subchannel-socket"},
+				Security: &channelzpb.Security{},
+			},
+			2: {
+				Ref:      &channelzpb.SocketRef{SocketId: 2, Name: "server-socket"},
+				Security: &channelzpb.Security{},
+			},
+		},
+		serverSockets: map[int64][]*channelzpb.SocketRef{
+			1: {{SocketId: 2}},
+		},
+	}
+}
+
+func TestChannelzMetricsSnapshot(t *testing.T) {
+	m := NewChannelzMetrics()
+	if err := m.Snapshot(context.Background(), testClient()); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.ChannelsTotal.Value(); got != 1 {
+		t.Errorf("ChannelsTotal = %d, want 1", got)
+	}
+	if got := m.SubchannelsTotal.Value(); got != 1 {
+		t.Errorf("SubchannelsTotal = %d, want 1", got)
+	}
+	if got := m.CallsFailedTotal.Value(); got != 3 {
+		t.Errorf("CallsFailedTotal = %d, want 3", got)
+	}
+}
+
+func TestChannelzTreeResolvesSubchannelsAndSockets(t *testing.T) {
+	tree, err := channelzTree(context.Background(), testClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tree.TopChannels) != 1 {
+		t.Fatalf("got %d top channels, want 1", len(tree.TopChannels))
+	}
+	subchannels := tree.TopChannels[0].Subchannels
+	if len(subchannels) != 1 {
+		t.Fatalf("got %d subchannels, want 1", len(subchannels))
+	}
+	if len(subchannels[0].Sockets) != 1 || subchannels[0].Sockets[0].Ref.Name != "subchannel-socket" {
+		t.Errorf("subchannel sockets = %+v, want the resolved subchannel-socket", subchannels[0].Sockets)
+	}
+
+	if len(tree.Servers) != 1 {
+		t.Fatalf("got %d servers, want 1", len(tree.Servers))
+	}
+	if len(tree.Servers[0].Sockets) != 1 || tree.Servers[0].Sockets[0].Ref.Name != "server-socket" {
+		t.Errorf("server sockets = %+v, want the resolved server-socket", tree.Servers[0].Sockets)
+	}
+}
+
+func TestChannelzHandlerAndDumpJSON(t *testing.T) {
+	c := testClient()
+
+	dump, err := DumpJSON(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dump) == 0 {
+		t.Fatal("expected non-empty JSON dump")
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/_status/channelz", nil)
+	Handler(c)(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("Handler returned status %d, body: %s", rec.Code, rec.Body.String())
+	}
+}
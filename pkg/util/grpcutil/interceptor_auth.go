@@ -0,0 +1,75 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// AuthPropagationUnaryClientInterceptor returns a grpc.UnaryClientInterceptor
+// that copies the given metadata keys from ctx's incoming metadata (as set
+// by a server handling an inbound RPC) onto the outgoing metadata of the
+// RPC being made, so that credentials attached to the original request
+// (e.g. a forwarded user identity) survive a hop through an intermediate
+// node rather than being dropped at the gateway.
+func AuthPropagationUnaryClientInterceptor(keys ...string) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(propagateAuthMetadata(ctx, keys), method, req, reply, cc, opts...)
+	}
+}
+
+// AuthPropagationStreamClientInterceptor is the streaming equivalent of
+// AuthPropagationUnaryClientInterceptor.
+func AuthPropagationStreamClientInterceptor(keys ...string) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(propagateAuthMetadata(ctx, keys), desc, cc, method, opts...)
+	}
+}
+
+func propagateAuthMetadata(ctx context.Context, keys []string) context.Context {
+	incoming, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	outgoing, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		outgoing = metadata.MD{}
+	} else {
+		outgoing = outgoing.Copy()
+	}
+	for _, key := range keys {
+		if vals := incoming.Get(key); len(vals) > 0 {
+			outgoing.Set(key, vals...)
+		}
+	}
+	return metadata.NewOutgoingContext(ctx, outgoing)
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// logVerbosity is the V() level that LoggingUnaryServerInterceptor and
+// LoggingUnaryClientInterceptor are gated behind, so operators who don't
+// want a line per RPC can leave it off by default.
+const logVerbosity = 1
+
+// payloadSize returns a best-effort wire size for m, used only for the log
+// line emitted by the logging interceptors; it is not meant to be exact.
+func payloadSize(m interface{}) int {
+	if sz, ok := m.(interface{ Size() int }); ok {
+		return sz.Size()
+	}
+	return 0
+}
+
+// LoggingUnaryServerInterceptor returns a grpc.UnaryServerInterceptor that
+// emits one log line per RPC, gated behind logVerbosity, with the method,
+// peer, status code, duration and response payload size.
+func LoggingUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if !(&logger{}).V(logVerbosity) {
+			return handler(ctx, req)
+		}
+		start := timeutil.Now()
+		resp, err := handler(ctx, req)
+		logRPC(ctx, info.FullMethod, timeutil.Since(start), err, payloadSize(resp))
+		return resp, err
+	}
+}
+
+// LoggingUnaryClientInterceptor returns a grpc.UnaryClientInterceptor with
+// the same behavior as LoggingUnaryServerInterceptor, for the client side
+// of a unary RPC.
+func LoggingUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if !(&logger{}).V(logVerbosity) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		start := timeutil.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		logRPC(ctx, method, timeutil.Since(start), err, payloadSize(reply))
+		return err
+	}
+}
+
+func logRPC(ctx context.Context, method string, dur time.Duration, err error, size int) {
+	var peerAddr interface{} = "<unknown>"
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		peerAddr = p.Addr
+	}
+	log.InfofDepth(ctx, 2, "rpc %s to %s: code=%s duration=%s size=%d",
+		method, peerAddr, status.Code(err), dur, size)
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+)
+
+// RecoveryUnaryServerInterceptor returns a grpc.UnaryServerInterceptor that
+// recovers panics raised by the handler, converts them to a codes.Internal
+// error so the client sees a normal RPC failure rather than a dropped
+// connection, and logs the panic via log.ErrorfDepth.
+func RecoveryUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.ErrorfDepth(ctx, 1, "panic serving %s: %v", info.FullMethod, r)
+				err = status.Errorf(codes.Internal, "panic serving %s: %v", info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// RecoveryStreamServerInterceptor is the streaming equivalent of
+// RecoveryUnaryServerInterceptor.
+func RecoveryStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+	) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.ErrorfDepth(ss.Context(), 1, "panic serving %s: %v", info.FullMethod, r)
+				err = status.Errorf(codes.Internal, "panic serving %s: %v", info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryOptions configures RetryUnaryClientInterceptor.
+type RetryOptions struct {
+	// MaxRetries bounds the number of additional attempts after the first.
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryOptions is used by RetryUnaryClientInterceptor when it is
+// given a zero RetryOptions.
+var DefaultRetryOptions = RetryOptions{
+	MaxRetries:     3,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+}
+
+// RetryUnaryClientInterceptor returns a grpc.UnaryClientInterceptor that
+// retries a unary RPC with exponential backoff when it fails with
+// codes.Unavailable or codes.ResourceExhausted, up to opts.MaxRetries
+// additional attempts. Any other error, or the context being canceled,
+// aborts the retry loop immediately.
+func RetryUnaryClientInterceptor(opts RetryOptions) grpc.UnaryClientInterceptor {
+	if opts == (RetryOptions{}) {
+		opts = DefaultRetryOptions
+	}
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		callOpts ...grpc.CallOption,
+	) error {
+		backoff := opts.InitialBackoff
+		var lastErr error
+		for attempt := 0; attempt <= opts.MaxRetries; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					return lastErr
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				if backoff > opts.MaxBackoff {
+					backoff = opts.MaxBackoff
+				}
+			}
+
+			lastErr = invoker(ctx, method, req, reply, cc, callOpts...)
+			if lastErr == nil {
+				return nil
+			}
+			switch status.Code(lastErr) {
+			case codes.Unavailable, codes.ResourceExhausted:
+				continue
+			default:
+				return lastErr
+			}
+		}
+		return lastErr
+	}
+}
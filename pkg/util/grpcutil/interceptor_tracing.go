@@ -0,0 +1,81 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"github.com/opentracing/opentracing-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// TracingUnaryClientInterceptor returns a grpc.UnaryClientInterceptor that,
+// if ctx carries an active opentracing.Span, injects its context into the
+// outgoing gRPC metadata so the callee can continue the same trace.
+func TracingUnaryClientInterceptor(tracer opentracing.Tracer) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(injectSpan(ctx, tracer), method, req, reply, cc, opts...)
+	}
+}
+
+// TracingStreamClientInterceptor is the streaming equivalent of
+// TracingUnaryClientInterceptor.
+func TracingStreamClientInterceptor(tracer opentracing.Tracer) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(injectSpan(ctx, tracer), desc, cc, method, opts...)
+	}
+}
+
+type metadataCarrier struct {
+	metadata.MD
+}
+
+func (c metadataCarrier) Set(key, val string) {
+	c.MD[key] = append(c.MD[key], val)
+}
+
+func injectSpan(ctx context.Context, tracer opentracing.Tracer) context.Context {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ctx
+	}
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		md = metadata.MD{}
+	} else {
+		md = md.Copy()
+	}
+	carrier := metadataCarrier{md}
+	if err := tracer.Inject(span.Context(), opentracing.HTTPHeaders, carrier); err != nil {
+		return ctx
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
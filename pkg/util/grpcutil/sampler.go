@@ -0,0 +1,218 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/petermattis/goid"
+
+	"github.com/cockroachdb/cockroach/pkg/util/metric"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+// SampleRule describes one entry of a LogSampler: log lines whose format
+// string matches FormatPattern, and one of whose error-typed args matches
+// ArgPattern, are printed at most once per MinInterval for a given Key.
+type SampleRule struct {
+	// Name identifies the rule for the samples_suppressed_total{rule=...}
+	// counter; it should be short and stable.
+	Name string
+	// FormatPattern is matched against the log line's format string.
+	FormatPattern *regexp.Regexp
+	// ArgPattern is matched against the Error() of any error-typed
+	// argument. A rule only fires if at least one argument matches.
+	ArgPattern *regexp.Regexp
+	// MinInterval is the minimum time between two prints sharing the same
+	// Key.
+	MinInterval time.Duration
+	// Key computes the dedup key for a matching log line. It defaults to
+	// the calling goroutine id, which reproduces the historical behavior of
+	// shouldPrint: don't let one noisy goroutine drown out everything else.
+	// Passing "method+peer" or "error class" lets distinct failures that
+	// happen to share a goroutine (or happen on different goroutines but
+	// are really the same failure) be sampled independently/together.
+	Key func(format string, args []interface{}) string
+}
+
+func (r SampleRule) matches(format string, args []interface{}) (string, bool) {
+	if !r.FormatPattern.MatchString(format) {
+		return "", false
+	}
+	for _, arg := range args {
+		if err, ok := arg.(error); ok && r.ArgPattern.MatchString(err.Error()) {
+			key := r.Name
+			if r.Key != nil {
+				key = fmt.Sprintf("%s/%s", r.Name, r.Key(format, args))
+			} else {
+				key = fmt.Sprintf("%s/%d", r.Name, goid.Get())
+			}
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// LogSampler throttles noisy, repetitive gRPC log lines. Rules are
+// evaluated in order; the first rule whose FormatPattern and ArgPattern
+// both match decides whether the line is sampled. A line matching no rule
+// is always printed.
+type LogSampler struct {
+	rules []SampleRule
+	now   func() time.Time
+
+	mu struct {
+		syncutil.Mutex
+		last map[string]time.Time // rule name + Key -> last print time
+	}
+
+	suppressed map[string]*metric.Counter // rule name -> counter
+}
+
+// NewLogSampler constructs a LogSampler evaluating rules in order.
+func NewLogSampler(rules ...SampleRule) *LogSampler {
+	s := &LogSampler{
+		rules:      rules,
+		now:        timeutil.Now,
+		suppressed: make(map[string]*metric.Counter, len(rules)),
+	}
+	s.mu.last = make(map[string]time.Time)
+	for _, r := range rules {
+		s.suppressed[r.Name] = metric.NewCounter(metric.Metadata{
+			Name: fmt.Sprintf("rpc.grpc_log_sampler.%s.samples_suppressed_total", metricNameComponent(r.Name)),
+			Help: fmt.Sprintf("Number of gRPC log lines suppressed by the %q sampling rule", r.Name),
+		})
+	}
+	return s
+}
+
+// metricNameComponent adapts a SampleRule.Name (e.g. "transport-failed")
+// for use as a dotted metric name component, matching the underscore
+// convention used by the rest of this package's metrics (e.g.
+// rpc.channelz.channels_total).
+func metricNameComponent(ruleName string) string {
+	return strings.Replace(ruleName, "-", "_", -1)
+}
+
+// ShouldPrint reports whether a Warningf-style log line should be printed,
+// consulting and updating the sampler's dedup state as a side effect.
+func (s *LogSampler) ShouldPrint(format string, args ...interface{}) bool {
+	for _, r := range s.rules {
+		key, ok := r.matches(format, args)
+		if !ok {
+			continue
+		}
+		now := s.now()
+		s.mu.Lock()
+		last, seen := s.mu.last[key]
+		doPrint := !(seen && now.Sub(last) < r.MinInterval)
+		if doPrint {
+			s.mu.last[key] = now
+		}
+		s.mu.Unlock()
+		if !doPrint {
+			s.suppressed[r.Name].Inc(1)
+		}
+		return doPrint
+	}
+	return true
+}
+
+// Suppressed returns the samples_suppressed_total counter for the named
+// rule, or nil if no such rule is registered.
+func (s *LogSampler) Suppressed(rule string) *metric.Counter {
+	return s.suppressed[rule]
+}
+
+// Metrics returns the samples_suppressed_total counter for every configured
+// rule, in rule order, so a caller (e.g. server startup, once
+// defaultSampler is reachable from pkg/server's metric registry) can
+// register each with registry.AddMetric and make dropped-log-line counts
+// operator-visible instead of only reachable through Suppressed in tests.
+func (s *LogSampler) Metrics() []*metric.Counter {
+	out := make([]*metric.Counter, len(s.rules))
+	for i, r := range s.rules {
+		out[i] = s.suppressed[r.Name]
+	}
+	return out
+}
+
+// https://github.com/grpc/grpc-go/blob/v1.7.0/clientconn.go#L937
+var (
+	transportFailedRe   = regexp.MustCompile("^" + regexp.QuoteMeta("grpc: addrConn.resetTransport failed to create client transport:"))
+	connectionRefusedRe = regexp.MustCompile(
+		strings.Join([]string{
+			// *nix
+			regexp.QuoteMeta("connection refused"),
+			// Windows
+			regexp.QuoteMeta("No connection could be made because the target machine actively refused it"),
+			// Host removed from the network and no longer resolvable:
+			// https://github.com/golang/go/blob/go1.8.3/src/net/net.go#L566
+			regexp.QuoteMeta("no such host"),
+		}, "|"),
+	)
+	securityHandshakeRe = regexp.MustCompile(
+		regexp.QuoteMeta("grpc: Server.Serve failed to complete security handshake"),
+	)
+	http2HandleStreamsRe = regexp.MustCompile(
+		regexp.QuoteMeta("transport: http2Server.HandleStreams"),
+	)
+	eofOnReadRe = regexp.MustCompile(regexp.QuoteMeta("EOF"))
+)
+
+// anyErrorRe matches any error argument; it is used by rules whose
+// FormatPattern alone is specific enough to identify the failure.
+var anyErrorRe = regexp.MustCompile(".")
+
+// anyFormatRe matches any format string; it is used by rules (like
+// eof-on-read below) whose ArgPattern alone is specific enough to
+// identify the failure, regardless of which log line it shows up under.
+var anyFormatRe = regexp.MustCompile(".*")
+
+// defaultSampler is the LogSampler consulted by (*logger).Warningf. Its
+// rule set mirrors the set of connection-noise patterns this package has
+// historically rate-limited, plus a few more gRPC-internal messages that
+// are known to repeat at high frequency during partial network outages.
+var defaultSampler = NewLogSampler(
+	SampleRule{
+		Name:          "transport-failed",
+		FormatPattern: transportFailedRe,
+		ArgPattern:    connectionRefusedRe,
+		MinInterval:   time.Minute,
+	},
+	SampleRule{
+		Name:          "security-handshake-failed",
+		FormatPattern: securityHandshakeRe,
+		ArgPattern:    anyErrorRe,
+		MinInterval:   time.Minute,
+	},
+	SampleRule{
+		Name:          "http2-handle-streams",
+		FormatPattern: http2HandleStreamsRe,
+		ArgPattern:    anyErrorRe,
+		MinInterval:   time.Minute,
+	},
+	SampleRule{
+		Name:          "eof-on-read",
+		FormatPattern: anyFormatRe,
+		ArgPattern:    eofOnReadRe,
+		MinInterval:   time.Minute,
+	},
+)
@@ -0,0 +1,123 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+)
+
+func TestLogSampler(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := NewLogSampler(SampleRule{
+		Name:          "test-rule",
+		FormatPattern: regexp.MustCompile("^boom$"),
+		ArgPattern:    regexp.MustCompile("refused"),
+		MinInterval:   time.Minute,
+		Key:           func(string, []interface{}) string { return "fixed-key" },
+	})
+	s.now = func() time.Time { return now }
+
+	err := errors.New("connection refused")
+	if !s.ShouldPrint("boom", err) {
+		t.Fatal("expected first occurrence to print")
+	}
+	if s.ShouldPrint("boom", err) {
+		t.Fatal("expected second occurrence within MinInterval to be suppressed")
+	}
+	if got := s.Suppressed("test-rule").Count(); got != 1 {
+		t.Fatalf("samples_suppressed_total = %d, want 1", got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if !s.ShouldPrint("boom", err) {
+		t.Fatal("expected occurrence past MinInterval to print")
+	}
+
+	if !s.ShouldPrint("no match here", err) {
+		t.Fatal("expected non-matching format to always print")
+	}
+}
+
+func TestLogSamplerMetricsAreDistinctPerRule(t *testing.T) {
+	s := NewLogSampler(
+		SampleRule{Name: "rule-one", FormatPattern: regexp.MustCompile("^a$"), ArgPattern: regexp.MustCompile(".")},
+		SampleRule{Name: "rule-two", FormatPattern: regexp.MustCompile("^b$"), ArgPattern: regexp.MustCompile(".")},
+	)
+
+	metrics := s.Metrics()
+	if len(metrics) != 2 {
+		t.Fatalf("got %d metrics, want 2", len(metrics))
+	}
+	if metrics[0] == metrics[1] {
+		t.Fatal("expected distinct counters per rule, got the same counter for both")
+	}
+
+	// Before the fix, both rules shared one metric.Metadata.Name, which (once
+	// registered with a real registry) would make incrementing one rule's
+	// counter indistinguishable from incrementing the other's. Simulate that
+	// by checking the two counters really are independent.
+	s.Suppressed("rule-one").Inc(1)
+	if got := s.Suppressed("rule-one").Count(); got != 1 {
+		t.Errorf("rule-one count = %d, want 1", got)
+	}
+	if got := s.Suppressed("rule-two").Count(); got != 0 {
+		t.Errorf("rule-two count = %d, want 0 (unaffected by rule-one)", got)
+	}
+}
+
+func TestDefaultSamplerSamplesEOFUnderAnyFormat(t *testing.T) {
+	now := time.Unix(0, 0)
+	defaultSampler.now = func() time.Time { return now }
+	defer func() { defaultSampler.now = timeutil.Now }()
+
+	err := errors.New("EOF")
+	if !defaultSampler.ShouldPrint("some unrelated log line: %v", err) {
+		t.Fatal("expected first occurrence to print")
+	}
+	if defaultSampler.ShouldPrint("some unrelated log line: %v", err) {
+		t.Fatal("expected second occurrence within MinInterval to be suppressed by eof-on-read, not just http2-handle-streams")
+	}
+	if got := defaultSampler.Suppressed("eof-on-read").Count(); got != 1 {
+		t.Errorf("eof-on-read samples_suppressed_total = %d, want 1", got)
+	}
+}
+
+func TestLogSamplerDefaultKeyIsPerGoroutine(t *testing.T) {
+	s := NewLogSampler(SampleRule{
+		Name:          "per-goroutine",
+		FormatPattern: regexp.MustCompile("^boom$"),
+		ArgPattern:    regexp.MustCompile("refused"),
+		MinInterval:   time.Hour,
+	})
+
+	err := errors.New("connection refused")
+	if !s.ShouldPrint("boom", err) {
+		t.Fatal("expected first occurrence to print")
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- s.ShouldPrint("boom", err)
+	}()
+	if !<-done {
+		t.Fatal("expected a different goroutine to print independently of the dedup key above")
+	}
+}
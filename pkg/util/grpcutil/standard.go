@@ -0,0 +1,82 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package grpcutil
+
+import (
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+)
+
+// StandardServerOptions and StandardDialOptions are only the composition
+// point the original request asked for: a single place that builds the
+// standard interceptor chain. The other half of that request — updating
+// pkg/rpc and the SQL gateway to build their grpc.Server/ClientConn options
+// by calling these instead of assembling their own ad-hoc interceptors —
+// is NOT done here. Neither package is part of this checkout, so there is
+// no existing call site to replace; whoever owns pkg/rpc and the SQL
+// gateway still needs to switch them over to these.
+
+// StandardServerOptions returns the grpc.ServerOption pair that every
+// CockroachDB gRPC server (the inter-node RPC server and the SQL pgwire/
+// gRPC-gateway listener alike) should be constructed with: panic recovery
+// outermost, so a handler panic always becomes a codes.Internal error
+// instead of tearing down the connection, then per-RPC logging. Callers
+// that need additional interceptors (e.g. auth) should prepend/append to
+// these slices with ChainUnaryServer/ChainStreamServer rather than
+// installing their own one-off interceptor, so the ordering stays
+// consistent across servers.
+func StandardServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(ChainUnaryServer(
+			RecoveryUnaryServerInterceptor(),
+			LoggingUnaryServerInterceptor(),
+		)),
+		grpc.StreamInterceptor(ChainStreamServer(
+			RecoveryStreamServerInterceptor(),
+		)),
+	}
+}
+
+// StandardDialOptions returns the grpc.DialOption pair that every
+// CockroachDB gRPC client connection should be constructed with: trace
+// propagation, then per-RPC logging. tracer may be nil, in which case
+// opentracing.NoopTracer is used and the tracing interceptors inject no
+// trace context, even when ctx carries a span.
+//
+// RetryUnaryClientInterceptor is deliberately not part of this set: blindly
+// retrying a unary RPC on codes.Unavailable/codes.ResourceExhausted is only
+// safe when the RPC is known to be idempotent. Most inter-node RPCs (Raft
+// transport, lease operations, KV batches) are not, since the server may
+// have already applied the request before the client observed the
+// transient failure, and retrying would risk double-applying it. Callers
+// that know a given RPC is safe to retry should add
+// RetryUnaryClientInterceptor to their own per-call-site options with
+// grpc.WithChainUnaryInterceptor (or ChainUnaryClient, for consistency with
+// the rest of this package) rather than having it apply to every call.
+func StandardDialOptions(tracer opentracing.Tracer) []grpc.DialOption {
+	if tracer == nil {
+		tracer = opentracing.NoopTracer{}
+	}
+	return []grpc.DialOption{
+		grpc.WithUnaryInterceptor(ChainUnaryClient(
+			TracingUnaryClientInterceptor(tracer),
+			LoggingUnaryClientInterceptor(),
+		)),
+		grpc.WithStreamInterceptor(ChainStreamClient(
+			TracingStreamClientInterceptor(tracer),
+		)),
+	}
+}